@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/registration"
+)
+
+// ErrAccountNotFound is returned by AccountStore.Load when no account has
+// been persisted yet, signalling that the caller should register a new one.
+var ErrAccountNotFound = errors.New("acme account not found")
+
+// storedAccount is the JSON document persisted for a registered ACME
+// account: the registration resource plus the PEM-encoded account key.
+type storedAccount struct {
+	Email        string                 `json:"email"`
+	Registration *registration.Resource `json:"registration"`
+	PrivateKey   []byte                 `json:"privateKey"`
+}
+
+// AccountStore persists and reloads ACME account registrations so the
+// program doesn't register a brand new account (and burn Let's Encrypt's
+// account-creation rate limit) on every restart.
+type AccountStore interface {
+	Load(ctx context.Context) (*acmeUser, error)
+	Save(ctx context.Context, user *acmeUser) error
+}
+
+// keyVaultAccountStore stores the account under a Key Vault secret named
+// "<certName>-acme-account".
+type keyVaultAccountStore struct {
+	client     *azsecrets.Client
+	secretName string
+}
+
+func newKeyVaultAccountStore(client *azsecrets.Client, certName string) *keyVaultAccountStore {
+	return &keyVaultAccountStore{
+		client:     client,
+		secretName: certName + "-acme-account",
+	}
+}
+
+// newSecretsClient builds a Key Vault secrets client, used both to persist
+// the ACME account and to look up CSR/reuse-key secrets during renewal.
+func newSecretsClient(vaultName string, cred azcore.TokenCredential) (*azsecrets.Client, error) {
+	client, err := azsecrets.NewClient(
+		fmt.Sprintf("https://%s.vault.azure.net/", vaultName),
+		cred,
+		nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating Key Vault secrets client: %w", err)
+	}
+	return client, nil
+}
+
+func (s *keyVaultAccountStore) Load(ctx context.Context) (*acmeUser, error) {
+	resp, err := s.client.GetSecret(ctx, s.secretName, "", nil)
+	if err != nil {
+		if isSecretNotFound(err) {
+			return nil, ErrAccountNotFound
+		}
+		return nil, fmt.Errorf("getting account secret: %w", err)
+	}
+
+	if resp.Value == nil {
+		return nil, ErrAccountNotFound
+	}
+
+	var stored storedAccount
+	if err := json.Unmarshal([]byte(*resp.Value), &stored); err != nil {
+		return nil, fmt.Errorf("decoding stored account: %w", err)
+	}
+
+	key, err := certcrypto.ParsePEMPrivateKey(stored.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing stored account key: %w", err)
+	}
+
+	return &acmeUser{
+		Email:        stored.Email,
+		Registration: stored.Registration,
+		key:          key,
+	}, nil
+}
+
+func (s *keyVaultAccountStore) Save(ctx context.Context, user *acmeUser) error {
+	keyPEM, ok := pemEncodeKey(user.key)
+	if !ok {
+		return fmt.Errorf("unsupported account key type %T", user.key)
+	}
+
+	stored := storedAccount{
+		Email:        user.Email,
+		Registration: user.Registration,
+		PrivateKey:   keyPEM,
+	}
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("encoding account: %w", err)
+	}
+
+	value := string(data)
+	_, err = s.client.SetSecret(ctx, s.secretName, azsecrets.SetSecretParameters{Value: &value}, nil)
+	if err != nil {
+		return fmt.Errorf("saving account secret: %w", err)
+	}
+
+	return nil
+}
+
+func pemEncodeKey(key crypto.PrivateKey) ([]byte, bool) {
+	block := certcrypto.PEMBlock(key)
+	if block == nil {
+		return nil, false
+	}
+	return certcrypto.PEMEncode(key), true
+}
+
+// isSecretNotFound reports whether err is the Key Vault "not found" response
+// for a secret that has never been set.
+func isSecretNotFound(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == 404
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/go-acme/lego/v4/certcrypto"
+)
+
+// awssmAccountStore stores the ACME account as a secret in AWS Secrets
+// Manager, the AccountStore counterpart to awssmCertStore.
+type awssmAccountStore struct {
+	client     *secretsmanager.Client
+	secretName string
+}
+
+func newAWSSMAccountStore(client *secretsmanager.Client, certName string) *awssmAccountStore {
+	return &awssmAccountStore{client: client, secretName: certName + "-acme-account"}
+}
+
+func (s *awssmAccountStore) Load(ctx context.Context) (*acmeUser, error) {
+	out, err := s.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(s.secretName)})
+	if err != nil {
+		var notFound *types.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return nil, ErrAccountNotFound
+		}
+		return nil, fmt.Errorf("getting secret: %w", err)
+	}
+	if out.SecretString == nil {
+		return nil, ErrAccountNotFound
+	}
+
+	var stored storedAccount
+	if err := json.Unmarshal([]byte(*out.SecretString), &stored); err != nil {
+		return nil, fmt.Errorf("decoding stored account: %w", err)
+	}
+
+	key, err := certcrypto.ParsePEMPrivateKey(stored.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing stored account key: %w", err)
+	}
+
+	return &acmeUser{
+		Email:        stored.Email,
+		Registration: stored.Registration,
+		key:          key,
+	}, nil
+}
+
+func (s *awssmAccountStore) Save(ctx context.Context, user *acmeUser) error {
+	keyPEM, ok := pemEncodeKey(user.key)
+	if !ok {
+		return fmt.Errorf("unsupported account key type %T", user.key)
+	}
+
+	data, err := json.Marshal(storedAccount{
+		Email:        user.Email,
+		Registration: user.Registration,
+		PrivateKey:   keyPEM,
+	})
+	if err != nil {
+		return fmt.Errorf("encoding account: %w", err)
+	}
+	value := string(data)
+
+	_, err = s.client.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(s.secretName),
+		SecretString: aws.String(value),
+	})
+	if err == nil {
+		return nil
+	}
+
+	var notFound *types.ResourceNotFoundException
+	if !errors.As(err, &notFound) {
+		return fmt.Errorf("updating secret: %w", err)
+	}
+
+	if _, err := s.client.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+		Name:         aws.String(s.secretName),
+		SecretString: aws.String(value),
+	}); err != nil {
+		return fmt.Errorf("creating secret: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+)
+
+// fileAccountStore persists the ACME account as a JSON file on the local
+// filesystem, the AccountStore counterpart to fileCertStore.
+type fileAccountStore struct {
+	path string
+}
+
+func newFileAccountStore(dir, certName string) *fileAccountStore {
+	return &fileAccountStore{path: filepath.Join(dir, certName+"-acme-account.json")}
+}
+
+func (s *fileAccountStore) Load(ctx context.Context) (*acmeUser, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading account file: %w", err)
+	}
+
+	var stored storedAccount
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("decoding stored account: %w", err)
+	}
+
+	key, err := certcrypto.ParsePEMPrivateKey(stored.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing stored account key: %w", err)
+	}
+
+	return &acmeUser{
+		Email:        stored.Email,
+		Registration: stored.Registration,
+		key:          key,
+	}, nil
+}
+
+func (s *fileAccountStore) Save(ctx context.Context, user *acmeUser) error {
+	keyPEM, ok := pemEncodeKey(user.key)
+	if !ok {
+		return fmt.Errorf("unsupported account key type %T", user.key)
+	}
+
+	stored := storedAccount{
+		Email:        user.Email,
+		Registration: user.Registration,
+		PrivateKey:   keyPEM,
+	}
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("encoding account: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("creating account store directory: %w", err)
+	}
+	if err := os.WriteFile(s.path, data, 0o600); err != nil {
+		return fmt.Errorf("writing account file: %w", err)
+	}
+
+	return nil
+}
@@ -2,18 +2,24 @@ package main
 
 import (
 	"crypto"
-	"crypto/rsa"
+	"fmt"
 	"net/http"
+	"os"
 	"strings"
 	"sync"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/http01"
+	"github.com/go-acme/lego/v4/lego"
 	"github.com/go-acme/lego/v4/registration"
 )
 
 type acmeUser struct {
 	Email        string
 	Registration *registration.Resource
-	key          *rsa.PrivateKey
+	key          crypto.PrivateKey
 }
 
 func (u *acmeUser) GetEmail() string                        { return u.Email }
@@ -57,3 +63,88 @@ func (p *challengeProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Write([]byte(keyAuth))
 }
+
+// challengeConfig selects how the ACME authorization is solved.
+//
+// CHALLENGE_TYPE defaults to http01, preserving the original behavior of
+// serving tokens on port 80. Setting it to dns01 additionally requires
+// DNS_PROVIDER and allows DOMAINS to contain wildcard SANs.
+type challengeConfig struct {
+	Type         string
+	DNSProvider  string
+	DNSResolvers []string
+
+	// Provider, when set, is used as-is instead of the http01/dns01 provider
+	// setupChallengeProvider would otherwise construct. It has no
+	// environment-variable equivalent: it exists for the e2e test suite,
+	// which needs to route challenges through a fake challtestsrv-backed
+	// responder rather than binding a real port 80 or calling a real DNS
+	// provider.
+	Provider challenge.Provider
+}
+
+func loadChallengeConfig() challengeConfig {
+	var resolvers []string
+	if v := os.Getenv("DNS_RESOLVERS"); v != "" {
+		resolvers = strings.Split(v, ",")
+	}
+
+	return challengeConfig{
+		Type:         strings.ToLower(envWithDefault("CHALLENGE_TYPE", "http01")),
+		DNSProvider:  strings.ToLower(os.Getenv("DNS_PROVIDER")),
+		DNSResolvers: resolvers,
+	}
+}
+
+// setupChallengeProvider wires the lego client to solve authorizations using
+// the configured challenge type.
+func setupChallengeProvider(client *lego.Client, azureCred azcore.TokenCredential, cfg challengeConfig) error {
+	if cfg.Provider != nil {
+		switch cfg.Type {
+		case "", "http01":
+			return client.Challenge.SetHTTP01Provider(cfg.Provider)
+		case "dns01":
+			return client.Challenge.SetDNS01Provider(cfg.Provider, dnsChallengeOptions(cfg.DNSResolvers)...)
+		default:
+			return fmt.Errorf("unknown challenge type %q", cfg.Type)
+		}
+	}
+
+	switch cfg.Type {
+	case "", "http01":
+		return client.Challenge.SetHTTP01Provider(http01.NewProviderServer("", "80"))
+	case "dns01":
+		if cfg.DNSProvider == "" {
+			return fmt.Errorf("DNS_PROVIDER must be set when CHALLENGE_TYPE=dns01")
+		}
+
+		provider, err := newDNSProvider(cfg.DNSProvider, azureCred)
+		if err != nil {
+			return fmt.Errorf("creating DNS provider %q: %w", cfg.DNSProvider, err)
+		}
+
+		return client.Challenge.SetDNS01Provider(provider, dnsChallengeOptions(cfg.DNSResolvers)...)
+	default:
+		return fmt.Errorf("unknown challenge type %q", cfg.Type)
+	}
+}
+
+// parseKeyType maps an ACCOUNT_KEY_TYPE/CERT_KEY_TYPE value to the
+// certcrypto.KeyType lego expects. lego only supports RSA and ECDSA keys, so
+// EdDSA (Ed25519) is not an option here.
+func parseKeyType(value string) (certcrypto.KeyType, error) {
+	switch strings.ToUpper(value) {
+	case "RSA2048":
+		return certcrypto.RSA2048, nil
+	case "RSA3072":
+		return certcrypto.RSA3072, nil
+	case "RSA4096":
+		return certcrypto.RSA4096, nil
+	case "EC256":
+		return certcrypto.EC256, nil
+	case "EC384":
+		return certcrypto.EC384, nil
+	default:
+		return "", fmt.Errorf("unsupported key type %q", value)
+	}
+}
@@ -2,40 +2,71 @@ package main
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
 	"fmt"
-	"log"
-	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
 	gopkcs12 "software.sslmate.com/src/go-pkcs12"
 )
 
-func checkIfRenewalNeeded(ctx context.Context, client *azcertificates.Client, certName string, renewBeforeDays int) (bool, error) {
-	cert, err := client.GetCertificate(ctx, certName, "", nil)
+// azureCertStore is the CertStore backing the original behavior: certificate
+// metadata (for expiry checks) comes from the Key Vault certificate
+// resource, and its PFX comes from the secret of the same name that Key
+// Vault exposes alongside every certificate it holds.
+type azureCertStore struct {
+	certClient   *azcertificates.Client
+	secretClient *azsecrets.Client
+}
+
+func newAzureCertStore(certClient *azcertificates.Client, secretClient *azsecrets.Client) *azureCertStore {
+	return &azureCertStore{certClient: certClient, secretClient: secretClient}
+}
+
+func (s *azureCertStore) Get(ctx context.Context, name string) (*StoredCert, error) {
+	cert, err := s.certClient.GetCertificate(ctx, name, "", nil)
 	if err != nil {
-		return true, fmt.Errorf("getting certificate: %w", err)
+		if isSecretNotFound(err) {
+			return nil, ErrCertNotFound
+		}
+		return nil, fmt.Errorf("getting certificate: %w", err)
 	}
-
 	if cert.Attributes == nil || cert.Attributes.Expires == nil {
-		return true, fmt.Errorf("certificate attributes or expiration date is missing")
+		return nil, fmt.Errorf("certificate attributes or expiration date is missing")
 	}
 
-	expiresOn := *cert.Attributes.Expires
-	renewalDate := expiresOn.AddDate(0, 0, -renewBeforeDays)
+	secret, err := s.secretClient.GetSecret(ctx, name, "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("getting certificate secret: %w", err)
+	}
 
-	needsRenewal := time.Now().After(renewalDate)
-	if needsRenewal {
-		log.Printf("Certificate will expire on %v, renewal needed (threshold: %d days)", expiresOn, renewBeforeDays)
-	} else {
-		log.Printf("Certificate valid until %v (renewal threshold: %d days before expiration)", expiresOn, renewBeforeDays)
+	var pfxData []byte
+	if secret.Value != nil {
+		pfxData, err = base64.StdEncoding.DecodeString(*secret.Value)
+		if err != nil {
+			return nil, fmt.Errorf("decoding PFX secret: %w", err)
+		}
 	}
 
-	return needsRenewal, nil
+	return &StoredCert{PFXData: pfxData, NotAfter: *cert.Attributes.Expires}, nil
+}
+
+func (s *azureCertStore) Import(ctx context.Context, name string, pfxData []byte, password string) error {
+	certString := base64.StdEncoding.EncodeToString(pfxData)
+	_, err := s.certClient.ImportCertificate(ctx, name, azcertificates.ImportCertificateParameters{
+		Base64EncodedCertificate: &certString,
+		Password:                 &password,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("importing certificate: %w", err)
+	}
+	return nil
 }
 
 func convertToPFX(certPEM, keyPEM []byte, password string) ([]byte, error) {
@@ -44,17 +75,9 @@ func convertToPFX(certPEM, keyPEM []byte, password string) ([]byte, error) {
 		return nil, fmt.Errorf("failed to decode private key PEM")
 	}
 
-	privateKey, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	privateKey, err := parsePrivateKey(keyBlock.Bytes)
 	if err != nil {
-		key, err2 := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
-		if err2 != nil {
-			return nil, fmt.Errorf("parsing private key: PKCS1: %w, PKCS8: %w", err, err2)
-		}
-		var ok bool
-		privateKey, ok = key.(*rsa.PrivateKey)
-		if !ok {
-			return nil, fmt.Errorf("parsed key is not RSA")
-		}
+		return nil, fmt.Errorf("parsing private key: %w", err)
 	}
 
 	var certs []*x509.Certificate
@@ -89,14 +112,26 @@ func convertToPFX(certPEM, keyPEM []byte, password string) ([]byte, error) {
 	return pfxData, nil
 }
 
-func uploadToKeyVault(ctx context.Context, client *azcertificates.Client, certName string, pfxData []byte, password string) error {
-	certString := base64.StdEncoding.EncodeToString(pfxData)
-	_, err := client.ImportCertificate(ctx, certName, azcertificates.ImportCertificateParameters{
-		Base64EncodedCertificate: &certString,
-		Password:                 &password,
-	}, nil)
+// parsePrivateKey decodes a PEM-encoded PKCS1/PKCS8/EC private key into the
+// concrete type gopkcs12.Encode expects (RSA or ECDSA).
+func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
 	if err != nil {
-		return fmt.Errorf("importing certificate: %w", err)
+		return nil, err
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
 	}
-	return nil
 }
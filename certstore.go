@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrCertNotFound is returned by CertStore.Get when no certificate has been
+// imported under the given name yet.
+var ErrCertNotFound = errors.New("certificate not found")
+
+// StoredCert is what a CertStore knows about a previously imported
+// certificate: its raw PKCS#12 bytes (still protected by whatever password
+// it was imported with) and when it expires.
+type StoredCert struct {
+	PFXData  []byte
+	NotAfter time.Time
+}
+
+// CertStore persists issued certificates under a short name. Get takes no
+// password, which keeps renewal-due checks cheap and non-secret: every
+// backend must be able to report a certificate's expiry without decrypting
+// its PFX, so implementations that can't get the expiry for free (file,
+// awssm) keep it alongside the PFX in plaintext instead.
+type CertStore interface {
+	Get(ctx context.Context, name string) (*StoredCert, error)
+	Import(ctx context.Context, name string, pfxData []byte, password string) error
+}
+
+// checkIfRenewalNeeded reports whether certName is due for renewal,
+// regardless of which CertStore backend it lives in.
+func checkIfRenewalNeeded(ctx context.Context, store CertStore, certName string, renewBeforeDays int) (bool, error) {
+	stored, err := store.Get(ctx, certName)
+	if errors.Is(err, ErrCertNotFound) {
+		log.Printf("No existing certificate found for %s, issuing a new one", certName)
+		return true, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("getting certificate: %w", err)
+	}
+
+	renewalDate := stored.NotAfter.AddDate(0, 0, -renewBeforeDays)
+	needsRenewal := time.Now().After(renewalDate)
+	if needsRenewal {
+		log.Printf("Certificate will expire on %v, renewal needed (threshold: %d days)", stored.NotAfter, renewBeforeDays)
+	} else {
+		log.Printf("Certificate valid until %v (renewal threshold: %d days before expiration)", stored.NotAfter, renewBeforeDays)
+	}
+
+	return needsRenewal, nil
+}
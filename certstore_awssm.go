@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	gopkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// awssmCertStore stores certificates in AWS Secrets Manager, one secret per
+// certificate name. Secrets Manager only stores opaque strings, not
+// metadata, so each secret's value is a small JSON envelope carrying the PFX
+// alongside its NotAfter date in plaintext - the same trick fileCertStore
+// uses, so Get still doesn't need a password.
+type awssmCertStore struct {
+	client *secretsmanager.Client
+}
+
+func newAWSSMCertStore(client *secretsmanager.Client) *awssmCertStore {
+	return &awssmCertStore{client: client}
+}
+
+type awssmCertEnvelope struct {
+	PFXData  []byte    `json:"pfxData"`
+	NotAfter time.Time `json:"notAfter"`
+}
+
+func (s *awssmCertStore) Get(ctx context.Context, name string) (*StoredCert, error) {
+	out, err := s.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(name)})
+	if err != nil {
+		var notFound *types.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return nil, ErrCertNotFound
+		}
+		return nil, fmt.Errorf("getting secret: %w", err)
+	}
+	if out.SecretString == nil {
+		return nil, fmt.Errorf("secret %q has no string value", name)
+	}
+
+	var envelope awssmCertEnvelope
+	if err := json.Unmarshal([]byte(*out.SecretString), &envelope); err != nil {
+		return nil, fmt.Errorf("decoding stored certificate: %w", err)
+	}
+
+	return &StoredCert{PFXData: envelope.PFXData, NotAfter: envelope.NotAfter}, nil
+}
+
+func (s *awssmCertStore) Import(ctx context.Context, name string, pfxData []byte, password string) error {
+	_, leaf, _, err := gopkcs12.DecodeChain(pfxData, password)
+	if err != nil {
+		return fmt.Errorf("decoding PFX: %w", err)
+	}
+
+	data, err := json.Marshal(awssmCertEnvelope{PFXData: pfxData, NotAfter: leaf.NotAfter})
+	if err != nil {
+		return fmt.Errorf("encoding certificate envelope: %w", err)
+	}
+	value := string(data)
+
+	_, err = s.client.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(name),
+		SecretString: aws.String(value),
+	})
+	if err == nil {
+		return nil
+	}
+
+	var notFound *types.ResourceNotFoundException
+	if !errors.As(err, &notFound) {
+		return fmt.Errorf("updating secret: %w", err)
+	}
+
+	if _, err := s.client.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+		Name:         aws.String(name),
+		SecretString: aws.String(value),
+	}); err != nil {
+		return fmt.Errorf("creating secret: %w", err)
+	}
+
+	return nil
+}
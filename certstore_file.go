@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	gopkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// fileCertStore persists certificates as PFX files on the local filesystem,
+// for deployments that don't want Azure Key Vault at all. Get has no
+// password, so alongside "<name>.pfx" every Import also writes an
+// unencrypted "<name>.pem" copy of the leaf certificate purely so expiry can
+// be read back without decrypting anything.
+type fileCertStore struct {
+	dir string
+}
+
+func newFileCertStore(dir string) *fileCertStore {
+	return &fileCertStore{dir: dir}
+}
+
+func (s *fileCertStore) Get(ctx context.Context, name string) (*StoredCert, error) {
+	pfxData, err := os.ReadFile(s.pfxPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCertNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading PFX file: %w", err)
+	}
+
+	pemData, err := os.ReadFile(s.pemPath(name))
+	if err != nil {
+		return nil, fmt.Errorf("reading certificate PEM file: %w", err)
+	}
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, fmt.Errorf("decoding certificate PEM file")
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing certificate: %w", err)
+	}
+
+	return &StoredCert{PFXData: pfxData, NotAfter: leaf.NotAfter}, nil
+}
+
+func (s *fileCertStore) Import(ctx context.Context, name string, pfxData []byte, password string) error {
+	_, leaf, _, err := gopkcs12.DecodeChain(pfxData, password)
+	if err != nil {
+		return fmt.Errorf("decoding PFX: %w", err)
+	}
+
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("creating certificate store directory: %w", err)
+	}
+
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw})
+	if err := os.WriteFile(s.pemPath(name), pemData, 0o600); err != nil {
+		return fmt.Errorf("writing certificate PEM file: %w", err)
+	}
+
+	if err := os.WriteFile(s.pfxPath(name), pfxData, 0o600); err != nil {
+		return fmt.Errorf("writing PFX file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *fileCertStore) pfxPath(name string) string { return filepath.Join(s.dir, name+".pfx") }
+func (s *fileCertStore) pemPath(name string) string { return filepath.Join(s.dir, name+".pem") }
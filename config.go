@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileCertConfig describes one certificate managed via CONFIG_FILE. It
+// mirrors certJob but uses plain strings for fields (key type, challenge,
+// interval) so it can be parsed straight from YAML/JSON before validation.
+type fileCertConfig struct {
+	Domains         []string            `yaml:"domains" json:"domains"`
+	CertName        string              `yaml:"certName" json:"certName"`
+	KeyVaultName    string              `yaml:"keyVaultName" json:"keyVaultName"`
+	KeyType         string              `yaml:"keyType" json:"keyType"`
+	Challenge       fileChallengeConfig `yaml:"challenge" json:"challenge"`
+	RenewBeforeDays int                 `yaml:"renewBeforeDays" json:"renewBeforeDays"`
+	CheckInterval   string              `yaml:"checkInterval" json:"checkInterval"`
+}
+
+type fileChallengeConfig struct {
+	Type        string `yaml:"type" json:"type"`
+	DNSProvider string `yaml:"dnsProvider" json:"dnsProvider"`
+}
+
+// fileConfig is the top-level CONFIG_FILE document: a default email plus one
+// entry per certificate, each scheduled independently.
+type fileConfig struct {
+	Email        string           `yaml:"email" json:"email"`
+	Certificates []fileCertConfig `yaml:"certificates" json:"certificates"`
+}
+
+// loadFileConfig reads a multi-certificate configuration from path. The
+// format is picked from the file extension: ".json" for JSON, anything else
+// for YAML.
+func loadFileConfig(path string) (*fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	var cfg fileConfig
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &cfg)
+	} else {
+		err = yaml.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing config file: %w", err)
+	}
+
+	if len(cfg.Certificates) == 0 {
+		return nil, fmt.Errorf("config file defines no certificates")
+	}
+
+	return &cfg, nil
+}
+
+// buildJobFromFileEntry validates and converts one CONFIG_FILE certificate
+// entry into a certJob, applying the same defaults as the legacy
+// environment-variable configuration.
+func buildJobFromFileEntry(e fileCertConfig, email string) (certJob, error) {
+	if len(e.Domains) == 0 {
+		return certJob{}, fmt.Errorf("certificate %q has no domains", e.CertName)
+	}
+	if e.CertName == "" {
+		return certJob{}, fmt.Errorf("certificate entry is missing certName")
+	}
+	if certStoreBackend() == "azurekv" && e.KeyVaultName == "" {
+		return certJob{}, fmt.Errorf("certificate %q is missing keyVaultName", e.CertName)
+	}
+
+	keyType, err := parseKeyType(envDefaultString(e.KeyType, "RSA2048"))
+	if err != nil {
+		return certJob{}, fmt.Errorf("certificate %q: %w", e.CertName, err)
+	}
+
+	checkInterval := 24 * time.Hour
+	if e.CheckInterval != "" {
+		d, err := time.ParseDuration(e.CheckInterval)
+		if err != nil {
+			return certJob{}, fmt.Errorf("certificate %q: invalid checkInterval: %w", e.CertName, err)
+		}
+		checkInterval = d
+	}
+
+	renewBeforeDays := e.RenewBeforeDays
+	if renewBeforeDays == 0 {
+		renewBeforeDays = 30
+	}
+
+	// DNS resolvers are an operational detail shared by the whole
+	// deployment, so they still come from the environment even when
+	// per-certificate challenge type/provider come from the config file.
+	challenge := loadChallengeConfig()
+	challenge.Type = strings.ToLower(envDefaultString(e.Challenge.Type, "http01"))
+	challenge.DNSProvider = strings.ToLower(e.Challenge.DNSProvider)
+
+	return certJob{
+		Domains:         e.Domains,
+		Email:           email,
+		KeyVaultName:    e.KeyVaultName,
+		CertName:        e.CertName,
+		PFXPassword:     os.Getenv("PFX_PASSWORD"),
+		RenewBeforeDays: renewBeforeDays,
+		CheckInterval:   checkInterval,
+		Challenge:       challenge,
+		Renew:           loadRenewConfig(),
+		AccountKeyType:  keyType,
+		CertKeyType:     keyType,
+	}, nil
+}
+
+func envDefaultString(value, defaultValue string) string {
+	if value != "" {
+		return value
+	}
+	return defaultValue
+}
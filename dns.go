@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/providers/dns/azuredns"
+	"github.com/go-acme/lego/v4/providers/dns/cloudflare"
+	"github.com/go-acme/lego/v4/providers/dns/rfc2136"
+	"github.com/go-acme/lego/v4/providers/dns/route53"
+)
+
+// newDNSProvider builds the challenge.Provider for the configured
+// DNS_PROVIDER. The azuredns provider reuses the same credential used to
+// talk to Key Vault so a single MSI/service principal can manage both the
+// certificate secret and the _acme-challenge TXT records.
+func newDNSProvider(name string, azureCred azcore.TokenCredential) (challenge.Provider, error) {
+	switch name {
+	case "azuredns":
+		cfg := azuredns.NewDefaultConfig()
+		cfg.SubscriptionID = os.Getenv("AZURE_DNS_SUBSCRIPTION_ID")
+		cfg.ResourceGroup = os.Getenv("AZURE_DNS_RESOURCE_GROUP")
+		cfg.ZoneName = os.Getenv("AZURE_DNS_ZONE_NAME")
+		return azuredns.NewDNSProviderPublic(cfg, azureCred)
+	case "route53":
+		return route53.NewDNSProvider()
+	case "cloudflare":
+		return cloudflare.NewDNSProvider()
+	case "rfc2136":
+		return rfc2136.NewDNSProvider()
+	case "manual":
+		// dns01.NewDNSProviderManual's Present blocks reading a confirmation
+		// from stdin, which this program never has attached: it runs
+		// unattended on a ticker (see runScheduler/runJob), so every renewal
+		// would either hang the job's goroutine forever or fail on EOF.
+		// There's no out-of-band way yet to signal "TXT record is in place"
+		// to a detached container, so refuse this provider instead of
+		// hanging or silently failing on every check interval.
+		return nil, fmt.Errorf("DNS provider %q requires an attached terminal and cannot be used in this unattended scheduler", name)
+	default:
+		return nil, fmt.Errorf("unsupported DNS provider %q", name)
+	}
+}
+
+// dnsChallengeOptions builds the dns01.ChallengeOptions used to poll DNS
+// propagation. When resolvers is empty lego falls back to the system
+// resolver and authoritative nameservers.
+func dnsChallengeOptions(resolvers []string) []dns01.ChallengeOption {
+	if len(resolvers) == 0 {
+		return nil
+	}
+	return []dns01.ChallengeOption{dns01.AddRecursiveNameservers(resolvers)}
+}
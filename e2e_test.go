@@ -0,0 +1,389 @@
+//go:build e2e
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/network"
+	"github.com/testcontainers/testcontainers-go/wait"
+	gopkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// This file exercises the real ACME flow end-to-end against Boulder's Pebble
+// (a small ACME server meant for testing) and its companion challtestsrv (a
+// mock DNS server plus HTTP-01/DNS-01 challenge responder), both started as
+// throwaway containers. It requires a local Docker daemon and needs to pull
+// the letsencrypt/pebble and letsencrypt/pebble-challtestsrv images, so it's
+// excluded from the default build with the e2e tag; run it with:
+//
+//	go test -tags e2e ./...
+const (
+	pebbleImage       = "letsencrypt/pebble:latest"
+	challtestsrvImage = "letsencrypt/pebble-challtestsrv:latest"
+	testDomain        = "acme-azure.e2e.test"
+)
+
+// fakeCertStore is an in-memory CertStore standing in for Key Vault, so the
+// e2e suite doesn't need real Azure credentials.
+type fakeCertStore struct {
+	certs map[string]*StoredCert
+}
+
+func newFakeCertStore() *fakeCertStore {
+	return &fakeCertStore{certs: make(map[string]*StoredCert)}
+}
+
+func (s *fakeCertStore) Get(ctx context.Context, name string) (*StoredCert, error) {
+	cert, ok := s.certs[name]
+	if !ok {
+		return nil, ErrCertNotFound
+	}
+	return cert, nil
+}
+
+func (s *fakeCertStore) Import(ctx context.Context, name string, pfxData []byte, password string) error {
+	_, leaf, _, err := gopkcs12.DecodeChain(pfxData, password)
+	if err != nil {
+		return fmt.Errorf("decoding PFX: %w", err)
+	}
+	s.certs[name] = &StoredCert{PFXData: pfxData, NotAfter: leaf.NotAfter}
+	return nil
+}
+
+// fakeAccountStore is an in-memory AccountStore standing in for Key
+// Vault/file/AWS Secrets Manager, so the e2e suite can exercise
+// processCertificate's real account bookkeeping without persisting anything.
+type fakeAccountStore struct {
+	user *acmeUser
+}
+
+func newFakeAccountStore() *fakeAccountStore {
+	return &fakeAccountStore{}
+}
+
+func (s *fakeAccountStore) Load(ctx context.Context) (*acmeUser, error) {
+	if s.user == nil {
+		return nil, ErrAccountNotFound
+	}
+	return s.user, nil
+}
+
+func (s *fakeAccountStore) Save(ctx context.Context, user *acmeUser) error {
+	s.user = user
+	return nil
+}
+
+// pebbleEnv is a running Pebble + challtestsrv pair, reachable from the test
+// process (DirectoryURL, CACertPath) and controllable through challtestsrv's
+// management API (mgmt*).
+type pebbleEnv struct {
+	DirectoryURL string
+	CACertPath   string
+	mgmtBaseURL  string
+}
+
+// startPebbleEnv brings up challtestsrv and Pebble on a shared Docker
+// network, points Pebble's DNS resolution and HTTP-01 validation at
+// challtestsrv, and registers testDomain's mock A record.
+func startPebbleEnv(t *testing.T) *pebbleEnv {
+	t.Helper()
+	ctx := context.Background()
+
+	net, err := network.New(ctx)
+	if err != nil {
+		t.Fatalf("creating network: %v", err)
+	}
+	t.Cleanup(func() { _ = net.Remove(ctx) })
+
+	challtestsrv, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		Started: true,
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        challtestsrvImage,
+			Cmd:          []string{"pebble-challtestsrv", "-defaultIPv6", "", "-defaultIPv4", ""},
+			ExposedPorts: []string{"8053/udp", "8055/tcp", "5002/tcp"},
+			Networks:     []string{net.Name},
+			NetworkAliases: map[string][]string{
+				net.Name: {"challtestsrv"},
+			},
+			WaitingFor: wait.ForListeningPort("8055/tcp"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("starting challtestsrv: %v", err)
+	}
+	t.Cleanup(func() { _ = challtestsrv.Terminate(ctx) })
+
+	challtestsrvIP, err := challtestsrv.ContainerIP(ctx)
+	if err != nil {
+		t.Fatalf("getting challtestsrv container IP: %v", err)
+	}
+
+	mgmtPort, err := challtestsrv.MappedPort(ctx, "8055/tcp")
+	if err != nil {
+		t.Fatalf("getting challtestsrv management port: %v", err)
+	}
+	mgmtHost, err := challtestsrv.Host(ctx)
+	if err != nil {
+		t.Fatalf("getting challtestsrv host: %v", err)
+	}
+	mgmtBaseURL := fmt.Sprintf("http://%s:%s", mgmtHost, mgmtPort.Port())
+
+	env := &pebbleEnv{mgmtBaseURL: mgmtBaseURL}
+	env.setDefaultIPv4(t, challtestsrvIP)
+
+	pebbleConfig := fmt.Sprintf(`{
+		"pebble": {
+			"listenAddress": "0.0.0.0:14000",
+			"managementListenAddress": "0.0.0.0:15000",
+			"certificate": "test/certs/localhost/cert.pem",
+			"privateKey": "test/certs/localhost/key.pem",
+			"httpPort": 5002,
+			"tlsPort": 5001,
+			"ocspResponderURL": "",
+			"externalAccountBindingRequired": false,
+			"dnsServer": "%s:8053"
+		}
+	}`, challtestsrvIP)
+
+	pebble, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		Started: true,
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        pebbleImage,
+			Entrypoint:   []string{"pebble", "-config", "/test/config/pebble-config.json", "-strict", "false"},
+			ExposedPorts: []string{"14000/tcp"},
+			Networks:     []string{net.Name},
+			Files: []testcontainers.ContainerFile{{
+				ContainerFilePath: "/test/config/pebble-config.json",
+				FileMode:          0o644,
+				Reader:            bytes.NewReader([]byte(pebbleConfig)),
+			}},
+			WaitingFor: wait.ForListeningPort("14000/tcp"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("starting pebble: %v", err)
+	}
+	t.Cleanup(func() { _ = pebble.Terminate(ctx) })
+
+	pebbleHost, err := pebble.Host(ctx)
+	if err != nil {
+		t.Fatalf("getting pebble host: %v", err)
+	}
+	pebblePort, err := pebble.MappedPort(ctx, "14000/tcp")
+	if err != nil {
+		t.Fatalf("getting pebble directory port: %v", err)
+	}
+
+	env.DirectoryURL = fmt.Sprintf("https://%s:%s/dir", pebbleHost, pebblePort.Port())
+
+	// Pebble's TLS certificate is self-signed and only meant for test use.
+	// Rather than fabricate a CA certificate, pull Pebble's own minica root
+	// out of the container and hand it to processCertificate via
+	// LEGO_CA_CERTIFICATES, the same env var lego.NewConfig reads in
+	// production.
+	caCertReader, err := pebble.CopyFileFromContainer(ctx, "/test/certs/pebble.minica.pem")
+	if err != nil {
+		t.Fatalf("copying pebble CA certificate: %v", err)
+	}
+	defer caCertReader.Close()
+	caCertPEM, err := io.ReadAll(caCertReader)
+	if err != nil {
+		t.Fatalf("reading pebble CA certificate: %v", err)
+	}
+
+	caCertPath := filepath.Join(t.TempDir(), "pebble-ca.pem")
+	if err := os.WriteFile(caCertPath, caCertPEM, 0o600); err != nil {
+		t.Fatalf("writing pebble CA certificate: %v", err)
+	}
+	env.CACertPath = caCertPath
+
+	return env
+}
+
+func (e *pebbleEnv) setDefaultIPv4(t *testing.T, ip string) {
+	t.Helper()
+	e.mgmtPost(t, "/set-default-ipv4", map[string]string{"ip": ip})
+}
+
+func (e *pebbleEnv) addHTTP01(t *testing.T, token, content string) {
+	t.Helper()
+	e.mgmtPost(t, "/add-http01", map[string]string{"token": token, "content": content})
+}
+
+func (e *pebbleEnv) delHTTP01(t *testing.T, token string) {
+	t.Helper()
+	e.mgmtPost(t, "/del-http01", map[string]string{"token": token})
+}
+
+func (e *pebbleEnv) setTXT(t *testing.T, host, value string) {
+	t.Helper()
+	e.mgmtPost(t, "/set-txt", map[string]string{"host": host, "value": value})
+}
+
+func (e *pebbleEnv) clearTXT(t *testing.T, host string) {
+	t.Helper()
+	e.mgmtPost(t, "/clear-txt", map[string]string{"host": host})
+}
+
+func (e *pebbleEnv) mgmtPost(t *testing.T, path string, body map[string]string) {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("encoding challtestsrv request: %v", err)
+	}
+	resp, err := http.Post(e.mgmtBaseURL+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("calling challtestsrv %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("challtestsrv %s returned %s", path, resp.Status)
+	}
+}
+
+// http01ChalltestsrvProvider implements challenge.Provider by pushing the
+// expected token/keyAuth into challtestsrv's HTTP-01 responder, which Pebble
+// is configured to query directly.
+type http01ChalltestsrvProvider struct {
+	env *pebbleEnv
+	t   *testing.T
+}
+
+func (p *http01ChalltestsrvProvider) Present(domain, token, keyAuth string) error {
+	p.env.addHTTP01(p.t, token, keyAuth)
+	return nil
+}
+
+func (p *http01ChalltestsrvProvider) CleanUp(domain, token, keyAuth string) error {
+	p.env.delHTTP01(p.t, token)
+	return nil
+}
+
+// dns01ChalltestsrvProvider implements challenge.Provider by pushing the
+// expected _acme-challenge TXT record into challtestsrv's mock DNS server.
+type dns01ChalltestsrvProvider struct {
+	env *pebbleEnv
+	t   *testing.T
+}
+
+func (p *dns01ChalltestsrvProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value := dns01.GetRecord(domain, keyAuth)
+	p.env.setTXT(p.t, fqdn, value)
+	return nil
+}
+
+func (p *dns01ChalltestsrvProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, _ := dns01.GetRecord(domain, keyAuth)
+	p.env.clearTXT(p.t, fqdn)
+	return nil
+}
+
+// newE2EJob builds a certJob for challengeType, to be processed against a
+// fresh in-memory fakeCertStore/fakeAccountStore so no real Key Vault is
+// needed.
+func newE2EJob(challengeType string) certJob {
+	return certJob{
+		Domains:         []string{testDomain},
+		Email:           "e2e@example.com",
+		CertName:        "e2e-cert",
+		RenewBeforeDays: 30,
+		AccountKeyType:  certcrypto.EC256,
+		CertKeyType:     certcrypto.EC256,
+		Challenge:       challengeConfig{Type: challengeType},
+	}
+}
+
+func TestEndToEndHTTP01(t *testing.T) {
+	env := startPebbleEnv(t)
+	job := newE2EJob("http01")
+
+	store := obtainE2ECertificate(t, env, job, &http01ChalltestsrvProvider{env: env, t: t})
+	assertCertStored(t, store, job)
+}
+
+func TestEndToEndDNS01(t *testing.T) {
+	env := startPebbleEnv(t)
+	job := newE2EJob("dns01")
+
+	store := obtainE2ECertificate(t, env, job, &dns01ChalltestsrvProvider{env: env, t: t})
+	assertCertStored(t, store, job)
+}
+
+// TestEndToEndAlreadyValidSkipsRenewal pre-seeds store with a certificate
+// that doesn't expire for a long time and asserts that checkIfRenewalNeeded
+// reports no renewal is due, without talking to Pebble at all.
+func TestEndToEndAlreadyValidSkipsRenewal(t *testing.T) {
+	store := newFakeCertStore()
+	store.certs["e2e-cert"] = &StoredCert{NotAfter: time.Now().Add(89 * 24 * time.Hour)}
+
+	needsRenewal, err := checkIfRenewalNeeded(context.Background(), store, "e2e-cert", 30)
+	if err != nil {
+		t.Fatalf("checkIfRenewalNeeded: %v", err)
+	}
+	if needsRenewal {
+		t.Fatal("expected a certificate valid for 89 more days not to need renewal with a 30 day threshold")
+	}
+}
+
+// obtainE2ECertificate points job at env and provider and runs it through the
+// real processCertificate, the same function the scheduler calls in
+// production, against a fresh in-memory fakeCertStore/fakeAccountStore. This
+// is what actually exercises main.go's renewal logic (and would have caught
+// a CERT_STORE backend that panics or refuses to start) instead of
+// reimplementing ACME registration and certificate issuance here.
+func obtainE2ECertificate(t *testing.T, env *pebbleEnv, job certJob, provider challenge.Provider) *fakeCertStore {
+	t.Helper()
+
+	t.Setenv("ACME_DIRECTORY_URL", env.DirectoryURL)
+	t.Setenv("LEGO_CA_CERTIFICATES", env.CACertPath)
+
+	job.Challenge.Provider = provider
+
+	store := newFakeCertStore()
+	accountStore := newFakeAccountStore()
+
+	if err := processCertificate(context.Background(), job, store, accountStore, nil, nil, emailConfig{}); err != nil {
+		t.Fatalf("processing certificate: %v", err)
+	}
+
+	return store
+}
+
+func assertCertStored(t *testing.T, store *fakeCertStore, job certJob) {
+	t.Helper()
+
+	stored, err := store.Get(context.Background(), job.CertName)
+	if err != nil {
+		t.Fatalf("getting stored certificate: %v", err)
+	}
+
+	_, leaf, _, err := gopkcs12.DecodeChain(stored.PFXData, job.PFXPassword)
+	if err != nil {
+		t.Fatalf("decoding PFX: %v", err)
+	}
+
+	found := false
+	for _, name := range leaf.DNSNames {
+		if name == testDomain {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Fatalf("expected leaf certificate SANs %v to contain %q", leaf.DNSNames, testDomain)
+	}
+}
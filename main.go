@@ -2,342 +2,250 @@ package main
 
 import (
 	"context"
-	"crypto"
-	"crypto/rand"
-	"crypto/rsa"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
-	"net/smtp"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
-	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azcertificates"
-	"github.com/go-acme/lego/v4/certificate"
-	"github.com/go-acme/lego/v4/challenge/http01"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/go-acme/lego/v4/lego"
 	"github.com/go-acme/lego/v4/registration"
 )
 
-type User struct {
-	Email        string
-	Registration *registration.Resource
-	key          *rsa.PrivateKey
-}
-
-func (u *User) GetEmail() string {
-	return u.Email
-}
-
-func (u *User) GetRegistration() *registration.Resource {
-	return u.Registration
-}
-
-func (u *User) GetPrivateKey() crypto.PrivateKey {
-	return u.key
-}
-
-type EmailConfig struct {
-	Enabled   bool
-	SMTPHost  string
-	SMTPPort  string
-	Username  string
-	Password  string
-	FromEmail string
-	ToEmail   string
-}
+func main() {
+	notifyCfg := loadEmailConfig()
 
-func getEmailConfig() EmailConfig {
-	return EmailConfig{
-		Enabled:   os.Getenv("NOTIFY_EMAIL_ENABLED") == "true",
-		SMTPHost:  os.Getenv("SMTP_HOST"),
-		SMTPPort:  getEnvWithDefault("SMTP_PORT", "587"),
-		Username:  os.Getenv("SMTP_USERNAME"),
-		Password:  os.Getenv("SMTP_PASSWORD"),
-		FromEmail: getEnvWithDefault("SMTP_FROM", os.Getenv("EMAIL")), // Default to the Let's Encrypt email
-		ToEmail:   getEnvWithDefault("SMTP_TO", os.Getenv("EMAIL")),   // Default to the Let's Encrypt email
+	pool, err := newVaultClientPool()
+	if err != nil {
+		log.Fatalf("Error initializing Azure clients: %v", err)
 	}
-}
 
-func sendErrorNotification(config EmailConfig, subject, message string) error {
-	if !config.Enabled {
-		return nil
+	jobs, err := loadJobs()
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
 	}
 
-	if config.SMTPHost == "" || config.Username == "" || config.Password == "" {
-		return fmt.Errorf("incomplete SMTP configuration")
+	log.Printf("Managing %d certificate(s)", len(jobs))
+	for _, job := range jobs {
+		log.Printf("[%s] domains=%v challenge=%s account-key=%s cert-key=%s check-interval=%v",
+			job.CertName, job.Domains, job.Challenge.Type, job.AccountKeyType, job.CertKeyType, job.CheckInterval)
 	}
 
-	auth := smtp.PlainAuth("", config.Username, config.Password, config.SMTPHost)
-
-	body := fmt.Sprintf("Subject: %s\r\n"+
-		"From: %s\r\n"+
-		"To: %s\r\n"+
-		"Content-Type: text/plain; charset=UTF-8\r\n"+
-		"\r\n"+
-		"%s", subject, config.FromEmail, config.ToEmail, message)
+	runScheduler(context.Background(), jobs, pool, notifyCfg)
+}
 
-	err := smtp.SendMail(
-		config.SMTPHost+":"+config.SMTPPort,
-		auth,
-		config.FromEmail,
-		[]string{config.ToEmail},
-		[]byte(body),
-	)
+// loadJobs builds the list of certificates to manage, either from
+// CONFIG_FILE (multiple certificates, independently scheduled) or from the
+// legacy flat environment variables (a single certificate).
+func loadJobs() ([]certJob, error) {
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		return loadJobsFromFile(path)
+	}
 
+	job, err := legacyJobFromEnv()
 	if err != nil {
-		log.Printf("Failed to send notification email: %v", err)
-		return err
+		return nil, err
 	}
 
-	log.Printf("Notification email sent successfully to %s", config.ToEmail)
-	return nil
+	return []certJob{job}, nil
 }
 
-func getEnvWithDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
-
-func main() {
-	checkInterval := getEnvDuration("CHECK_INTERVAL", 24*time.Hour)
-	renewBeforeDays := getEnvInt("RENEW_BEFORE_DAYS", 30)
-	domains := strings.Split(os.Getenv("DOMAINS"), ",")
-
-	// Validate domains
-	if len(domains) == 0 || (len(domains) == 1 && domains[0] == "") {
-		log.Fatal("No domains specified. Please set DOMAINS environment variable")
+func loadJobsFromFile(path string) ([]certJob, error) {
+	cfg, err := loadFileConfig(path)
+	if err != nil {
+		return nil, err
 	}
 
-	email := os.Getenv("EMAIL")
+	email := cfg.Email
 	if email == "" {
-		log.Fatal("No email specified. Please set EMAIL environment variable")
-	}
-
-	keyVaultName := os.Getenv("AZURE_KEYVAULT_NAME")
-	if keyVaultName == "" {
-		log.Fatal("No Key Vault name specified. Please set AZURE_KEYVAULT_NAME environment variable")
+		email = os.Getenv("EMAIL")
 	}
-
-	certName := os.Getenv("AZURE_CERT_NAME")
-	if certName == "" {
-		log.Fatal("No certificate name specified. Please set AZURE_CERT_NAME environment variable")
+	if email == "" {
+		return nil, fmt.Errorf("no email specified in %s or EMAIL environment variable", path)
 	}
 
-	pfxPassword := os.Getenv("PFX_PASSWORD") // Empty string if not set
-
-	log.Printf("Starting certificate management for domains: %v", domains)
-	log.Printf("Check interval: %v, Renewal threshold: %d days", checkInterval, renewBeforeDays)
-
-	emailConfig := getEmailConfig()
-
-	for {
-		err := processCertificates(domains, email, keyVaultName, certName, pfxPassword, renewBeforeDays)
+	jobs := make([]certJob, 0, len(cfg.Certificates))
+	for _, entry := range cfg.Certificates {
+		job, err := buildJobFromFileEntry(entry, email)
 		if err != nil {
-			log.Printf("Error processing certificates: %v", err)
-			if emailConfig.Enabled {
-				errorMessage := fmt.Sprintf("Error processing certificates for domains: %v\n\nError details:\n%v", domains, err)
-				if notifyErr := sendErrorNotification(emailConfig, "Certificate Processing Error", errorMessage); notifyErr != nil {
-					log.Printf("Failed to send error notification: %v", notifyErr)
-				}
-			}
+			return nil, fmt.Errorf("%s: %w", path, err)
 		}
-
-		log.Printf("Waiting %v before next check...", checkInterval)
-		time.Sleep(checkInterval)
+		jobs = append(jobs, job)
 	}
+
+	return jobs, nil
 }
 
-func processCertificates(domains []string, email, keyVaultName, certName, pfxPassword string, renewBeforeDays int) error {
-	// Check if certificate needs renewal
-	needsRenewal, err := checkIfRenewalNeeded(keyVaultName, certName, renewBeforeDays)
-	if err != nil {
-		log.Printf("Error checking certificate renewal: %v", err)
+func legacyJobFromEnv() (certJob, error) {
+	domains := strings.Split(os.Getenv("DOMAINS"), ",")
+	if len(domains) == 0 || (len(domains) == 1 && domains[0] == "") {
+		return certJob{}, fmt.Errorf("no domains specified. Please set DOMAINS environment variable")
 	}
 
-	if !needsRenewal {
-		log.Printf("Certificate is still valid and not due for renewal")
-		return nil
+	email := os.Getenv("EMAIL")
+	if email == "" {
+		return certJob{}, fmt.Errorf("no email specified. Please set EMAIL environment variable")
 	}
 
-	// Create a user
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return fmt.Errorf("error generating private key: %v", err)
+	keyVaultName := os.Getenv("AZURE_KEYVAULT_NAME")
+	if certStoreBackend() == "azurekv" && keyVaultName == "" {
+		return certJob{}, fmt.Errorf("no Key Vault name specified. Please set AZURE_KEYVAULT_NAME environment variable")
 	}
 
-	user := &User{
-		Email: email,
-		key:   privateKey,
+	certName := os.Getenv("AZURE_CERT_NAME")
+	if certName == "" {
+		return certJob{}, fmt.Errorf("no certificate name specified. Please set AZURE_CERT_NAME environment variable")
 	}
 
-	config := lego.NewConfig(user)
-	config.CADirURL = "https://acme-v02.api.letsencrypt.org/directory"
-
-	client, err := lego.NewClient(config)
+	accountKeyType, err := parseKeyType(envWithDefault("ACCOUNT_KEY_TYPE", "RSA2048"))
 	if err != nil {
-		return fmt.Errorf("error creating client: %v", err)
+		return certJob{}, fmt.Errorf("invalid ACCOUNT_KEY_TYPE: %w", err)
 	}
 
-	// Solve HTTP-01 challenge
-	err = client.Challenge.SetHTTP01Provider(http01.NewProviderServer("", "80"))
+	certKeyType, err := parseKeyType(envWithDefault("CERT_KEY_TYPE", "RSA2048"))
 	if err != nil {
-		return fmt.Errorf("error setting up HTTP-01 provider: %v", err)
-	}
+		return certJob{}, fmt.Errorf("invalid CERT_KEY_TYPE: %w", err)
+	}
+
+	return certJob{
+		Domains:         domains,
+		Email:           email,
+		KeyVaultName:    keyVaultName,
+		CertName:        certName,
+		PFXPassword:     os.Getenv("PFX_PASSWORD"),
+		RenewBeforeDays: getEnvInt("RENEW_BEFORE_DAYS", 30),
+		CheckInterval:   getEnvDuration("CHECK_INTERVAL", 24*time.Hour),
+		Challenge:       loadChallengeConfig(),
+		Renew:           loadRenewConfig(),
+		AccountKeyType:  accountKeyType,
+		CertKeyType:     certKeyType,
+	}, nil
+}
 
-	// Register user
-	reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
-	if err != nil {
-		return fmt.Errorf("error registering user: %v", err)
-	}
-	user.Registration = reg
+// acmeDirectoryURL is the ACME server directory to use, defaulting to Let's
+// Encrypt's production endpoint. ACME_DIRECTORY_URL lets e2e tests (and
+// anyone else who needs a different CA, e.g. Let's Encrypt's staging
+// environment) point processCertificate elsewhere.
+func acmeDirectoryURL() string {
+	return envWithDefault("ACME_DIRECTORY_URL", lego.LEDirectoryProduction)
+}
 
-	// Request certificate
-	request := certificate.ObtainRequest{
-		Domains: domains,
-		Bundle:  true,
-	}
-	certificates, err := client.Certificate.Obtain(request)
+// processJob builds job's CertStore/AccountStore and (for the "azurekv"
+// backend) its Key Vault secrets client from pool, then hands off to
+// processCertificate. It's the seam between the scheduler, which only knows
+// about *vaultClientPool, and processCertificate, which only knows about the
+// CertStore/AccountStore interfaces - letting tests call processCertificate
+// directly against fakes without standing up a real pool.
+func processJob(ctx context.Context, job certJob, pool *vaultClientPool, notifyCfg emailConfig) error {
+	store, err := pool.certStore(ctx, job)
 	if err != nil {
-		return fmt.Errorf("error obtaining certificate: %v", err)
+		return fmt.Errorf("creating certificate store: %w", err)
 	}
 
-	// Convert to PFX
-	pfxData, err := convertToPFX(certificates.Certificate, certificates.PrivateKey, pfxPassword)
+	accountStore, err := pool.accountStore(ctx, job)
 	if err != nil {
-		return fmt.Errorf("error converting to PFX: %v", err)
+		return fmt.Errorf("creating account store: %w", err)
 	}
 
-	// Upload to Azure Key Vault
-	err = uploadToKeyVault(context.Background(), keyVaultName, certName, pfxData, pfxPassword)
-	if err != nil {
-		return fmt.Errorf("error uploading to Key Vault: %v", err)
+	var secretsClient *azsecrets.Client
+	if pool.certStoreBackend == "" || pool.certStoreBackend == "azurekv" {
+		secretsClient, err = pool.secrets(job.KeyVaultName)
+		if err != nil {
+			return err
+		}
 	}
 
-	log.Printf("Successfully processed certificates for domains: %v", domains)
-	return nil
+	return processCertificate(ctx, job, store, accountStore, secretsClient, pool.cred, notifyCfg)
 }
 
-func checkIfRenewalNeeded(keyVaultName, certName string, renewBeforeDays int) (bool, error) {
-	ctx := context.Background()
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
-	if err != nil {
-		return true, fmt.Errorf("failed to create credential: %v", err)
-	}
-
-	client, err := azcertificates.NewClient(
-		fmt.Sprintf("https://%s.vault.azure.net/", keyVaultName),
-		cred,
-		nil,
-	)
-	if err != nil {
-		return true, fmt.Errorf("failed to create client: %v", err)
-	}
-
-	cert, err := client.GetCertificate(ctx, certName, "", nil)
+// processCertificate checks whether job's certificate needs renewal and, if
+// so, obtains and uploads a new one via store and accountStore. secretsClient
+// is optional: when non-nil (the "azurekv" CERT_STORE backend) it's also used
+// to cache OCSP responses and to look up CSR_SECRET_NAME/REUSE_KEY secrets;
+// other backends simply skip those Key-Vault-only extras.
+func processCertificate(ctx context.Context, job certJob, store CertStore, accountStore AccountStore, secretsClient *azsecrets.Client, cred azcore.TokenCredential, notifyCfg emailConfig) error {
+	needsRenewal, err := checkIfRenewalNeeded(ctx, store, job.CertName, job.RenewBeforeDays)
 	if err != nil {
-		return true, fmt.Errorf("failed to get certificate: %v", err)
-	}
-
-	if cert.Attributes == nil || cert.Attributes.Expires == nil {
-		return true, fmt.Errorf("certificate attributes or expiration date is missing")
+		log.Printf("[%s] error checking certificate renewal: %v", job.CertName, err)
 	}
 
-	expiresOn := *cert.Attributes.Expires
-	renewalDate := expiresOn.AddDate(0, 0, -renewBeforeDays)
-
-	needsRenewal := time.Now().After(renewalDate)
-	if needsRenewal {
-		log.Printf("Certificate will expire on %v, renewal needed (threshold: %d days)", expiresOn, renewBeforeDays)
+	if status, err := checkOCSP(ctx, store, secretsClient, job.CertName, job.PFXPassword); err != nil {
+		log.Printf("[%s] error checking OCSP status: %v", job.CertName, err)
 	} else {
-		log.Printf("Certificate valid until %v (renewal threshold: %d days before expiration)", expiresOn, renewBeforeDays)
+		log.Printf("[%s] OCSP status: revoked=%v thisUpdate=%s nextUpdate=%s", job.CertName, status.Revoked, status.ThisUpdate, status.NextUpdate)
+		if status.Revoked {
+			needsRenewal = true
+			if notifyErr := sendErrorNotification(notifyCfg, "Certificate Revoked",
+				fmt.Sprintf("OCSP reports certificate %s as revoked (thisUpdate=%s, nextUpdate=%s). Forcing immediate renewal.",
+					job.CertName, status.ThisUpdate, status.NextUpdate)); notifyErr != nil {
+				log.Printf("[%s] failed to send revocation notification: %v", job.CertName, notifyErr)
+			}
+		}
 	}
 
-	return needsRenewal, nil
-}
-
-func convertToPFX(certPEM, keyPEM []byte, password string) ([]byte, error) {
-	// Create temporary files
-	certFile, err := os.CreateTemp("", "cert-*.pem")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create temp cert file: %v", err)
+	if !needsRenewal {
+		log.Printf("[%s] certificate is still valid and not due for renewal", job.CertName)
+		return nil
 	}
-	certFileName := certFile.Name()
-	certFile.Close()
-	defer os.Remove(certFileName)
 
-	keyFile, err := os.CreateTemp("", "key-*.pem")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create temp key file: %v", err)
+	user, err := accountStore.Load(ctx)
+	switch {
+	case errors.Is(err, ErrAccountNotFound):
+		accountKey, genErr := certcrypto.GeneratePrivateKey(job.AccountKeyType)
+		if genErr != nil {
+			return fmt.Errorf("generating account private key: %w", genErr)
+		}
+		user = &acmeUser{Email: job.Email, key: accountKey}
+	case err != nil:
+		return fmt.Errorf("loading ACME account: %w", err)
 	}
-	keyFileName := keyFile.Name()
-	keyFile.Close()
-	defer os.Remove(keyFileName)
 
-	pfxFile, err := os.CreateTemp("", "cert-*.pfx")
+	legoConfig := lego.NewConfig(user)
+	legoConfig.CADirURL = acmeDirectoryURL()
+	legoConfig.Certificate.KeyType = job.CertKeyType
+
+	client, err := lego.NewClient(legoConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp pfx file: %v", err)
+		return fmt.Errorf("creating ACME client: %w", err)
 	}
-	pfxFileName := pfxFile.Name()
-	pfxFile.Close()
-	defer os.Remove(pfxFileName)
 
-	// Write certificate and key to temporary files
-	if err := os.WriteFile(certFileName, certPEM, 0600); err != nil {
-		return nil, fmt.Errorf("failed to write cert file: %v", err)
-	}
-	if err := os.WriteFile(keyFileName, keyPEM, 0600); err != nil {
-		return nil, fmt.Errorf("failed to write key file: %v", err)
+	if err := setupChallengeProvider(client, cred, job.Challenge); err != nil {
+		return fmt.Errorf("setting up %s challenge: %w", job.Challenge.Type, err)
 	}
 
-	// Convert using OpenSSL with password if provided
-	args := []string{"pkcs12", "-export",
-		"-out", pfxFileName,
-		"-inkey", keyFileName,
-		"-in", certFileName}
-
-	if password != "" {
-		args = append(args, "-passout", "pass:"+password)
-	} else {
-		args = append(args, "-passout", "pass:")
-	}
+	if user.Registration == nil {
+		reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+		if err != nil {
+			return fmt.Errorf("registering user: %w", err)
+		}
+		user.Registration = reg
 
-	cmd := exec.Command("openssl", args...)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return nil, fmt.Errorf("openssl command failed: %v, output: %s", err, output)
+		if err := accountStore.Save(ctx, user); err != nil {
+			return fmt.Errorf("saving ACME account: %w", err)
+		}
 	}
 
-	return os.ReadFile(pfxFileName)
-}
-
-func uploadToKeyVault(ctx context.Context, vaultName, certName string, pfxData []byte, password string) error {
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	certificates, err := obtainCertificate(client.Certificate, job.Domains, job.Renew, secretsClient, store, job.CertName, job.PFXPassword)
 	if err != nil {
-		return fmt.Errorf("failed to create credential: %v", err)
+		return fmt.Errorf("obtaining certificate: %w", err)
 	}
 
-	client, err := azcertificates.NewClient(
-		fmt.Sprintf("https://%s.vault.azure.net/", vaultName),
-		cred,
-		nil,
-	)
+	pfxData, err := convertToPFX(certificates.Certificate, certificates.PrivateKey, job.PFXPassword)
 	if err != nil {
-		return fmt.Errorf("failed to create client: %v", err)
+		return fmt.Errorf("converting to PFX: %w", err)
 	}
 
-	certString := base64.StdEncoding.EncodeToString(pfxData)
-	_, err = client.ImportCertificate(ctx, certName, azcertificates.ImportCertificateParameters{
-		Base64EncodedCertificate: &certString,
-		Password:                 &password,
-	}, nil)
+	if err := store.Import(ctx, job.CertName, pfxData, job.PFXPassword); err != nil {
+		return fmt.Errorf("importing certificate: %w", err)
+	}
 
-	return err
+	log.Printf("[%s] successfully processed certificate for domains: %v", job.CertName, job.Domains)
+	return nil
 }
 
 func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
@@ -357,3 +265,10 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func envWithDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"golang.org/x/crypto/ocsp"
+	gopkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// ocspStatus summarizes the OCSP response for the certificate's current PFX.
+type ocspStatus struct {
+	Revoked    bool
+	ThisUpdate string
+	NextUpdate string
+}
+
+// checkOCSP fetches the leaf and issuer for certName from store, queries the
+// issuer's OCSP responder, and caches the response in Key Vault so it can
+// later be served via TLS stapling. It forces renewal by reporting
+// Revoked=true regardless of how much validity the certificate has left.
+//
+// The response cache is a plain Key Vault secret rather than part of
+// CertStore, so it's only available when secretsClient is non-nil (the
+// "azurekv" CERT_STORE backend).
+func checkOCSP(ctx context.Context, store CertStore, secretsClient *azsecrets.Client, certName, pfxPassword string) (*ocspStatus, error) {
+	leaf, issuer, err := loadCertChain(ctx, store, certName, pfxPassword)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate chain: %w", err)
+	}
+
+	if issuer == nil {
+		return nil, fmt.Errorf("no issuer certificate found for OCSP lookup")
+	}
+
+	if len(leaf.OCSPServer) == 0 {
+		return nil, fmt.Errorf("certificate has no OCSP responder URL")
+	}
+
+	resp, err := queryOCSP(leaf.OCSPServer[0], leaf, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("querying OCSP responder: %w", err)
+	}
+
+	if secretsClient != nil {
+		if err := cacheOCSPResponse(ctx, secretsClient, certName, resp); err != nil {
+			return nil, fmt.Errorf("caching OCSP response: %w", err)
+		}
+	}
+
+	return &ocspStatus{
+		Revoked:    resp.Status == ocsp.Revoked,
+		ThisUpdate: resp.ThisUpdate.String(),
+		NextUpdate: resp.NextUpdate.String(),
+	}, nil
+}
+
+// loadCertChain fetches the PFX stored for certName and splits it into the
+// leaf certificate and its immediate issuer.
+func loadCertChain(ctx context.Context, store CertStore, certName, pfxPassword string) (leaf, issuer *x509.Certificate, err error) {
+	stored, err := store.Get(ctx, certName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting certificate: %w", err)
+	}
+	if len(stored.PFXData) == 0 {
+		return nil, nil, fmt.Errorf("certificate has no PFX data")
+	}
+
+	_, leaf, caCerts, err := gopkcs12.DecodeChain(stored.PFXData, pfxPassword)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding PFX: %w", err)
+	}
+
+	if len(caCerts) > 0 {
+		issuer = caCerts[0]
+	}
+
+	return leaf, issuer, nil
+}
+
+// queryOCSP sends an OCSP request for leaf to responderURL over HTTP POST,
+// as required by RFC 6960 for non-GET-friendly request sizes.
+func queryOCSP(responderURL string, leaf, issuer *x509.Certificate) (*ocsp.Response, error) {
+	reqBytes, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating OCSP request: %w", err)
+	}
+
+	httpResp, err := http.Post(responderURL, "application/ocsp-request", bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, fmt.Errorf("posting OCSP request: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading OCSP response: %w", err)
+	}
+
+	return ocsp.ParseResponseForCert(body, leaf, issuer)
+}
+
+// cacheOCSPResponse stores the last-known-good OCSP response as a Key Vault
+// secret named "<certName>-ocsp-response" so operators can serve it via TLS
+// stapling without re-querying the responder on every handshake.
+func cacheOCSPResponse(ctx context.Context, secretsClient *azsecrets.Client, certName string, resp *ocsp.Response) error {
+	value := base64.StdEncoding.EncodeToString(resp.Raw)
+	_, err := secretsClient.SetSecret(ctx, certName+"-ocsp-response", azsecrets.SetSecretParameters{Value: &value}, nil)
+	if err != nil {
+		return fmt.Errorf("saving OCSP response secret: %w", err)
+	}
+	return nil
+}
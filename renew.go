@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/go-acme/lego/v4/certificate"
+	gopkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// renewConfig selects how a certificate is requested on each run.
+//
+// By default a fresh key is generated and a certificate is obtained for the
+// configured SANs. CSR_PATH/CSR_SECRET_NAME instead submit a pre-generated
+// CSR (obtaining a cert for exactly the SANs it encodes), and REUSE_KEY
+// reuses the private key from the currently stored PFX so downstream
+// key-pinned integrations (e.g. HPKP) keep working across renewals.
+type renewConfig struct {
+	CSRPath       string
+	CSRSecretName string
+	ReuseKey      bool
+}
+
+func loadRenewConfig() renewConfig {
+	return renewConfig{
+		CSRPath:       os.Getenv("CSR_PATH"),
+		CSRSecretName: os.Getenv("CSR_SECRET_NAME"),
+		ReuseKey:      os.Getenv("REUSE_KEY") == "true",
+	}
+}
+
+// obtainCertificate requests a certificate using client, honoring cfg's CSR
+// and key-reuse modes. secretsClient is only needed for the CSR_SECRET_NAME
+// mode, which reads the CSR from a Key Vault secret; store and certName are
+// only needed for REUSE_KEY, which reads the existing PFX back out of
+// whichever CertStore backend is configured.
+func obtainCertificate(client *certificate.Certifier, domains []string, cfg renewConfig, secretsClient *azsecrets.Client, store CertStore, certName, pfxPassword string) (*certificate.Resource, error) {
+	ctx := context.Background()
+
+	if cfg.CSRPath != "" || cfg.CSRSecretName != "" {
+		csr, err := loadCSR(ctx, cfg, secretsClient)
+		if err != nil {
+			return nil, fmt.Errorf("loading CSR: %w", err)
+		}
+
+		return client.ObtainForCSR(certificate.ObtainForCSRRequest{
+			CSR:    csr,
+			Bundle: true,
+		})
+	}
+
+	request := certificate.ObtainRequest{
+		Domains: domains,
+		Bundle:  true,
+	}
+
+	if cfg.ReuseKey {
+		key, err := loadExistingKey(ctx, store, certName, pfxPassword)
+		if err != nil {
+			return nil, fmt.Errorf("loading existing private key: %w", err)
+		}
+		request.PrivateKey = key
+	}
+
+	return client.Obtain(request)
+}
+
+// loadCSR reads a PEM-encoded CSR from CSR_PATH if set, otherwise from the
+// CSR_SECRET_NAME Key Vault secret.
+func loadCSR(ctx context.Context, cfg renewConfig, secretsClient *azsecrets.Client) (*x509.CertificateRequest, error) {
+	var csrPEM []byte
+
+	switch {
+	case cfg.CSRPath != "":
+		data, err := os.ReadFile(cfg.CSRPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", cfg.CSRPath, err)
+		}
+		csrPEM = data
+	case cfg.CSRSecretName != "":
+		if secretsClient == nil {
+			return nil, fmt.Errorf("CSR_SECRET_NAME requires CERT_STORE=azurekv (got %q)", certStoreBackend())
+		}
+		resp, err := secretsClient.GetSecret(ctx, cfg.CSRSecretName, "", nil)
+		if err != nil {
+			return nil, fmt.Errorf("getting CSR secret %q: %w", cfg.CSRSecretName, err)
+		}
+		if resp.Value == nil {
+			return nil, fmt.Errorf("CSR secret %q has no value", cfg.CSRSecretName)
+		}
+		csrPEM = []byte(*resp.Value)
+	default:
+		return nil, fmt.Errorf("neither CSR_PATH nor CSR_SECRET_NAME is set")
+	}
+
+	block, _ := pem.Decode(csrPEM)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode CSR PEM")
+	}
+
+	return x509.ParseCertificateRequest(block.Bytes)
+}
+
+// loadExistingKey fetches the PFX currently stored for certName and
+// extracts its private key so it can be reused for the next certificate.
+func loadExistingKey(ctx context.Context, store CertStore, certName, pfxPassword string) (crypto.PrivateKey, error) {
+	stored, err := store.Get(ctx, certName)
+	if err != nil {
+		return nil, fmt.Errorf("getting certificate: %w", err)
+	}
+	if len(stored.PFXData) == 0 {
+		return nil, fmt.Errorf("certificate has no PFX data")
+	}
+
+	key, _, err := gopkcs12.Decode(stored.PFXData, pfxPassword)
+	if err != nil {
+		return nil, fmt.Errorf("decoding PFX: %w", err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/go-acme/lego/v4/certcrypto"
+)
+
+// certJob is everything one certificate's renewal loop needs, whether it
+// came from CONFIG_FILE or the legacy flat environment variables.
+type certJob struct {
+	Domains         []string
+	Email           string
+	KeyVaultName    string
+	CertName        string
+	PFXPassword     string
+	RenewBeforeDays int
+	CheckInterval   time.Duration
+	Challenge       challengeConfig
+	Renew           renewConfig
+	AccountKeyType  certcrypto.KeyType
+	CertKeyType     certcrypto.KeyType
+}
+
+// vaultClientPool shares a single Azure credential and one client per Key
+// Vault across every scheduled certificate, instead of each job
+// authenticating and connecting independently. It also owns the CertStore
+// backend selected via CERT_STORE, lazily creating whichever client that
+// backend needs the first time a job asks for it.
+type vaultClientPool struct {
+	cred azcore.TokenCredential
+
+	certStoreBackend string
+	certStoreDir     string
+
+	mu            sync.Mutex
+	certClients   map[string]*azcertificates.Client
+	secretClients map[string]*azsecrets.Client
+	awsSecrets    *secretsmanager.Client
+}
+
+func newVaultClientPool() (*vaultClientPool, error) {
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating Azure credential: %w", err)
+	}
+
+	return &vaultClientPool{
+		cred:             cred,
+		certStoreBackend: certStoreBackend(),
+		certStoreDir:     envWithDefault("CERT_STORE_DIR", "./certstore"),
+		certClients:      make(map[string]*azcertificates.Client),
+		secretClients:    make(map[string]*azsecrets.Client),
+	}, nil
+}
+
+// certStoreBackend reads the CERT_STORE backend selection. It's a free
+// function rather than a vaultClientPool method so config.go and main.go can
+// decide whether AZURE_KEYVAULT_NAME/keyVaultName is required before a pool
+// even exists.
+func certStoreBackend() string {
+	return strings.ToLower(envWithDefault("CERT_STORE", "azurekv"))
+}
+
+// certStore returns the CertStore backend configured via CERT_STORE for
+// job's certificate: "azurekv" (default) keeps certificates in job's Key
+// Vault, "file" keeps them under CERT_STORE_DIR, and "awssm" keeps them in
+// AWS Secrets Manager.
+func (p *vaultClientPool) certStore(ctx context.Context, job certJob) (CertStore, error) {
+	switch p.certStoreBackend {
+	case "", "azurekv":
+		certClient, err := p.certificates(job.KeyVaultName)
+		if err != nil {
+			return nil, err
+		}
+		secretClient, err := p.secrets(job.KeyVaultName)
+		if err != nil {
+			return nil, err
+		}
+		return newAzureCertStore(certClient, secretClient), nil
+	case "file":
+		return newFileCertStore(p.certStoreDir), nil
+	case "awssm":
+		client, err := p.awsSecretsManager(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return newAWSSMCertStore(client), nil
+	default:
+		return nil, fmt.Errorf("unknown CERT_STORE backend %q", p.certStoreBackend)
+	}
+}
+
+// accountStore returns the AccountStore backend for job, using the same
+// CERT_STORE selection and backing clients as certStore so ACME account
+// bookkeeping moves to the same place as the certificate itself.
+func (p *vaultClientPool) accountStore(ctx context.Context, job certJob) (AccountStore, error) {
+	switch p.certStoreBackend {
+	case "", "azurekv":
+		secretClient, err := p.secrets(job.KeyVaultName)
+		if err != nil {
+			return nil, err
+		}
+		return newKeyVaultAccountStore(secretClient, job.CertName), nil
+	case "file":
+		return newFileAccountStore(p.certStoreDir, job.CertName), nil
+	case "awssm":
+		client, err := p.awsSecretsManager(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return newAWSSMAccountStore(client, job.CertName), nil
+	default:
+		return nil, fmt.Errorf("unknown CERT_STORE backend %q", p.certStoreBackend)
+	}
+}
+
+func (p *vaultClientPool) awsSecretsManager(ctx context.Context) (*secretsmanager.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.awsSecrets != nil {
+		return p.awsSecrets, nil
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+	p.awsSecrets = secretsmanager.NewFromConfig(cfg)
+	return p.awsSecrets, nil
+}
+
+func (p *vaultClientPool) certificates(vaultName string) (*azcertificates.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if client, ok := p.certClients[vaultName]; ok {
+		return client, nil
+	}
+
+	client, err := azcertificates.NewClient(fmt.Sprintf("https://%s.vault.azure.net/", vaultName), p.cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating Key Vault certificates client for %q: %w", vaultName, err)
+	}
+	p.certClients[vaultName] = client
+	return client, nil
+}
+
+func (p *vaultClientPool) secrets(vaultName string) (*azsecrets.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if client, ok := p.secretClients[vaultName]; ok {
+		return client, nil
+	}
+
+	client, err := newSecretsClient(vaultName, p.cred)
+	if err != nil {
+		return nil, err
+	}
+	p.secretClients[vaultName] = client
+	return client, nil
+}
+
+// jobFailure is one certificate's error from a single renewal attempt,
+// collected by runScheduler into a digest instead of emailed individually.
+type jobFailure struct {
+	CertName string
+	Err      error
+}
+
+// digestWindow is how long failures from independently-scheduled jobs are
+// batched before being emailed as a single notification.
+const digestWindow = time.Minute
+
+// runScheduler runs every job on its own ticker so each certificate can have
+// its own CheckInterval, and aggregates failures into a single digest email
+// rather than one per iteration. It blocks until ctx is cancelled.
+func runScheduler(ctx context.Context, jobs []certJob, pool *vaultClientPool, notifyCfg emailConfig) {
+	failures := make(chan jobFailure)
+
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job certJob) {
+			defer wg.Done()
+			runJob(ctx, job, pool, notifyCfg, failures)
+		}(job)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		collectFailures(ctx, failures, notifyCfg)
+	}()
+
+	wg.Wait()
+	close(failures)
+	<-done
+}
+
+func runJob(ctx context.Context, job certJob, pool *vaultClientPool, notifyCfg emailConfig, failures chan<- jobFailure) {
+	ticker := time.NewTicker(job.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := processJob(ctx, job, pool, notifyCfg); err != nil {
+			log.Printf("[%s] error processing certificate: %v", job.CertName, err)
+			select {
+			case failures <- jobFailure{CertName: job.CertName, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func collectFailures(ctx context.Context, failures <-chan jobFailure, notifyCfg emailConfig) {
+	var pending []jobFailure
+	timer := time.NewTimer(digestWindow)
+	defer timer.Stop()
+
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if err := sendErrorNotification(notifyCfg, "Certificate Processing Errors", formatDigest(pending)); err != nil {
+			log.Printf("Failed to send digest notification: %v", err)
+		}
+		pending = nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return
+		case f, ok := <-failures:
+			if !ok {
+				flush()
+				return
+			}
+			pending = append(pending, f)
+		case <-timer.C:
+			flush()
+			timer.Reset(digestWindow)
+		}
+	}
+}
+
+func formatDigest(failures []jobFailure) string {
+	msg := fmt.Sprintf("%d certificate(s) failed to process:\n\n", len(failures))
+	for _, f := range failures {
+		msg += fmt.Sprintf("- %s: %v\n", f.CertName, f.Err)
+	}
+	return msg
+}